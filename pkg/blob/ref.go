@@ -0,0 +1,123 @@
+/*
+Copyright 2013 The Camlistore Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package blob defines Ref, the value type that identifies a blob
+// by the digest of its contents, and is the algorithm-agile
+// replacement for the old *blobref.BlobRef pointer type and for the
+// one-off misc.SHA256Prefix helper.
+package blob
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// Ref identifies a blob by its cryptographic digest. Unlike the old
+// *blobref.BlobRef, Ref is a plain value: cheap to copy, comparable
+// with ==, and usable as a map key.
+type Ref struct {
+	hashName string
+	digest   string // full lowercase hex digest
+}
+
+var hashFuncs = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+}
+
+// RegisterHash makes a new hash algorithm usable by name, for
+// instance from the init function of a package implementing it.
+func RegisterHash(name string, fn func() hash.Hash) {
+	hashFuncs[name] = fn
+}
+
+// FromBytes returns the Ref of data under the named hash algorithm
+// ("sha1", "sha256", ...). It returns the zero Ref if the algorithm
+// isn't registered.
+func FromBytes(hashName string, data []byte) Ref {
+	fn, ok := hashFuncs[hashName]
+	if !ok {
+		return Ref{}
+	}
+	h := fn()
+	h.Write(data)
+	return Ref{hashName: hashName, digest: fmt.Sprintf("%x", h.Sum(nil))}
+}
+
+// FromString is a convenience wrapper around FromBytes for callers
+// that already have their data as a string.
+func FromString(hashName, s string) Ref {
+	return FromBytes(hashName, []byte(s))
+}
+
+// Parse parses a string of the form "<hashname>-<hexdigest>" (e.g.
+// "sha256-2c26b46b...") into a Ref, returning the zero Ref if s
+// isn't well-formed or names an unregistered hash.
+//
+// As a compatibility shim, a bare 40-character hex string with no
+// "<hashname>-" prefix is accepted as a legacy sha1 digest, so that
+// blobs named on disk before the hashname prefix was introduced
+// still parse.
+func Parse(s string) Ref {
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		hashName, digest := s[:i], s[i+1:]
+		if _, ok := hashFuncs[hashName]; ok && digest != "" {
+			return Ref{hashName: hashName, digest: digest}
+		}
+		return Ref{}
+	}
+	if len(s) == 40 && isHex(s) {
+		return Ref{hashName: "sha1", digest: s}
+	}
+	return Ref{}
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		switch {
+		case c >= '0' && c <= '9', c >= 'a' && c <= 'f', c >= 'A' && c <= 'F':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Valid reports whether r is a non-zero Ref.
+func (r Ref) Valid() bool { return r.hashName != "" && r.digest != "" }
+
+// HashName returns the name of the hash algorithm used, e.g. "sha1"
+// or "sha256".
+func (r Ref) HashName() string { return r.hashName }
+
+// Digest returns the raw digest bytes.
+func (r Ref) Digest() []byte {
+	b, _ := hex.DecodeString(r.digest)
+	return b
+}
+
+// String returns r in "<hashname>-<hexdigest>" form, e.g.
+// "sha256-2c26b46b...".
+func (r Ref) String() string {
+	if !r.Valid() {
+		return "<invalid-blob.Ref>"
+	}
+	return r.hashName + "-" + r.digest
+}
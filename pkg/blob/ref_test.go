@@ -0,0 +1,72 @@
+/*
+Copyright 2013 The Camlistore Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blob
+
+import "testing"
+
+func TestFromBytesAndParseRoundTrip(t *testing.T) {
+	ref := FromString("sha256", "hello")
+	if !ref.Valid() {
+		t.Fatalf("FromString returned invalid Ref")
+	}
+	s := ref.String()
+	parsed := Parse(s)
+	if parsed != ref {
+		t.Errorf("Parse(%q) = %v; want %v", s, parsed, ref)
+	}
+}
+
+func TestParseLegacyBareSHA1(t *testing.T) {
+	ref := FromString("sha1", "hello")
+	bare := ref.Digest()
+	legacy := Parse(hexString(bare))
+	if !legacy.Valid() {
+		t.Fatalf("Parse of bare legacy sha1 digest returned invalid Ref")
+	}
+	if legacy.HashName() != "sha1" {
+		t.Errorf("HashName() = %q; want sha1", legacy.HashName())
+	}
+	if legacy != ref {
+		t.Errorf("legacy-parsed Ref %v != directly constructed Ref %v", legacy, ref)
+	}
+}
+
+func hexString(b []byte) string {
+	const hexDigits = "0123456789abcdef"
+	out := make([]byte, len(b)*2)
+	for i, c := range b {
+		out[i*2] = hexDigits[c>>4]
+		out[i*2+1] = hexDigits[c&0xf]
+	}
+	return string(out)
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, s := range []string{"", "sha1-", "bogusalgo-abcd", "not40charshex"} {
+		if Parse(s).Valid() {
+			t.Errorf("Parse(%q).Valid() = true; want false", s)
+		}
+	}
+}
+
+func TestUsableAsMapKey(t *testing.T) {
+	m := map[Ref]bool{}
+	m[FromString("sha1", "a")] = true
+	if !m[FromString("sha1", "a")] {
+		t.Errorf("Ref not usable as a stable map key")
+	}
+}
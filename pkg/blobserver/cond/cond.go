@@ -0,0 +1,384 @@
+/*
+Copyright 2013 The Camlistore Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package cond registers the "cond" blobserver storage type, which
+// wraps other configured storages and routes each operation to one
+// of them based on a small expression language over the blob being
+// operated on. This lets an operator, for example, keep small
+// schema blobs on fast local disk while streaming large "bytes"
+// chunks to S3 or Swift, without any change to clients.
+package cond
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/blobserver"
+	"camlistore.org/pkg/jsonconfig"
+)
+
+func init() {
+	blobserver.RegisterStorageConstructor("cond", newFromConfig)
+}
+
+// storage dispatches to a fixed set of named backend storages,
+// chosen per operation by config.
+type storage struct {
+	backends map[string]blobserver.Storage
+	readName string
+	rmName   string
+	write    writeRule
+
+	// candidates is every backend the write rule could possibly
+	// have put a blob in, plus the read/remove backends
+	// themselves. A blob written under the write rule's size/type
+	// condition may have landed in a different backend than
+	// readName/rmName, so FetchStreaming, Stat, EnumerateBlobs and
+	// RemoveBlobs all have to consider every one of these, not just
+	// the configured read/remove backend.
+	candidates []blobserver.Storage
+}
+
+// writeRule is either a single named backend (name set, rest zero),
+// or an if/then/else rule evaluated against the incoming blob.
+type writeRule struct {
+	name string // non-empty: always write to this backend
+
+	expr expr
+	then string
+	els  string
+}
+
+func newFromConfig(config jsonconfig.Obj) (blobserver.Storage, error) {
+	s := &storage{backends: map[string]blobserver.Storage{}}
+
+	rawBackends, _ := config.Get("backends")
+	backendsObj, ok := rawBackends.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("cond: required object \"backends\" missing or malformed")
+	}
+	for name, rawSub := range backendsObj {
+		subMap, ok := rawSub.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("cond: backend %q config must be an object", name)
+		}
+		subObj := jsonconfig.Obj(subMap)
+		typ := subObj.RequiredString("type")
+		subConfigRaw, _ := subObj.Get("config")
+		subConfig, _ := subConfigRaw.(map[string]interface{})
+		st, err := blobserver.NewStorage(typ, jsonconfig.Obj(subConfig))
+		if err != nil {
+			return nil, fmt.Errorf("cond: constructing backend %q: %v", name, err)
+		}
+		s.backends[name] = st
+	}
+
+	s.readName = config.RequiredString("read")
+	s.rmName = config.RequiredString("remove")
+
+	rawWrite, ok := config.Get("write")
+	if !ok {
+		return nil, fmt.Errorf("cond: required key \"write\" missing")
+	}
+	rule, err := parseWriteRule(rawWrite)
+	if err != nil {
+		return nil, err
+	}
+	s.write = rule
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	// read and remove are required, so Validate above would already
+	// have failed if either were left unset; don't skip an empty
+	// name here; that would hide a nil backend panic until the
+	// first operation.
+	for _, name := range []string{s.readName, s.rmName} {
+		if _, ok := s.backends[name]; !ok {
+			return nil, fmt.Errorf("cond: unknown backend %q", name)
+		}
+	}
+	// write.name, write.then, write.els are alternatives; depending
+	// on which form of "write" was used, exactly one or two of them
+	// are legitimately empty.
+	for _, name := range []string{s.write.name, s.write.then, s.write.els} {
+		if name == "" {
+			continue
+		}
+		if _, ok := s.backends[name]; !ok {
+			return nil, fmt.Errorf("cond: unknown backend %q", name)
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, name := range []string{s.readName, s.rmName, s.write.name, s.write.then, s.write.els} {
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		s.candidates = append(s.candidates, s.backends[name])
+	}
+	return s, nil
+}
+
+func parseWriteRule(raw interface{}) (writeRule, error) {
+	switch v := raw.(type) {
+	case string:
+		return writeRule{name: v}, nil
+	case map[string]interface{}:
+		obj := jsonconfig.Obj(v)
+		ifStr := obj.RequiredString("if")
+		then := obj.RequiredString("then")
+		els := obj.RequiredString("else")
+		if err := obj.Validate(); err != nil {
+			return writeRule{}, err
+		}
+		e, err := parseExpr(ifStr)
+		if err != nil {
+			return writeRule{}, err
+		}
+		return writeRule{expr: e, then: then, els: els}, nil
+	default:
+		return writeRule{}, fmt.Errorf("cond: \"write\" must be a string or an object, got %T", raw)
+	}
+}
+
+// expr is a single "<field> <op> <value>" condition, such as
+// "size < 32k" or "type == schema".
+type expr struct {
+	field string
+	op    string
+	value string
+}
+
+func parseExpr(s string) (expr, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 3 {
+		return expr{}, fmt.Errorf("cond: malformed expression %q", s)
+	}
+	return expr{field: fields[0], op: fields[1], value: fields[2]}, nil
+}
+
+// blobInfo is all an expr needs to know about a blob in order to be
+// evaluated.
+type blobInfo struct {
+	size     int64
+	isSchema bool // the blob is JSON with a top-level "camliType" key
+}
+
+func (e expr) eval(b blobInfo) (bool, error) {
+	switch e.field {
+	case "size":
+		want, err := parseSize(e.value)
+		if err != nil {
+			return false, err
+		}
+		return compare(b.size, want, e.op)
+	case "type":
+		if e.value != "schema" && e.value != "raw" {
+			return false, fmt.Errorf("cond: unknown type value %q, want \"schema\" or \"raw\"", e.value)
+		}
+		got := b.isSchema == (e.value == "schema")
+		switch e.op {
+		case "==":
+			return got, nil
+		case "!=":
+			return !got, nil
+		default:
+			return false, fmt.Errorf("cond: operator %q not valid for \"type\"", e.op)
+		}
+	default:
+		return false, fmt.Errorf("cond: unknown field %q", e.field)
+	}
+}
+
+func compare(got, want int64, op string) (bool, error) {
+	switch op {
+	case "<":
+		return got < want, nil
+	case "<=":
+		return got <= want, nil
+	case ">":
+		return got > want, nil
+	case ">=":
+		return got >= want, nil
+	case "==":
+		return got == want, nil
+	case "!=":
+		return got != want, nil
+	default:
+		return false, fmt.Errorf("cond: unknown operator %q", op)
+	}
+}
+
+// parseSize parses a byte count, with an optional k/m/g suffix
+// (powers of 1024), e.g. "32k" is 32768.
+func parseSize(s string) (int64, error) {
+	mult := int64(1)
+	if n := len(s); n > 0 {
+		switch s[n-1] {
+		case 'k', 'K':
+			mult, s = 1<<10, s[:n-1]
+		case 'm', 'M':
+			mult, s = 1<<20, s[:n-1]
+		case 'g', 'G':
+			mult, s = 1<<30, s[:n-1]
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("cond: invalid size %q", s)
+	}
+	return n * mult, nil
+}
+
+// sniffSchema reports whether data looks like a Camlistore JSON
+// schema blob, i.e. a JSON object with a top-level "camliType" key,
+// as opposed to a raw content ("bytes") chunk.
+func sniffSchema(data []byte) bool {
+	var v map[string]interface{}
+	if json.Unmarshal(data, &v) != nil {
+		return false
+	}
+	_, ok := v["camliType"]
+	return ok
+}
+
+func (s *storage) backendFor(info blobInfo) (blobserver.Storage, error) {
+	if s.write.name != "" {
+		return s.backends[s.write.name], nil
+	}
+	matched, err := s.write.expr.eval(info)
+	if err != nil {
+		return nil, err
+	}
+	if matched {
+		return s.backends[s.write.then], nil
+	}
+	return s.backends[s.write.els], nil
+}
+
+func (s *storage) ReceiveBlob(br blob.Ref, source io.Reader) (blobserver.SizedBlobRef, error) {
+	data, err := ioutil.ReadAll(source)
+	if err != nil {
+		return blobserver.SizedBlobRef{}, err
+	}
+	dest, err := s.backendFor(blobInfo{size: int64(len(data)), isSchema: sniffSchema(data)})
+	if err != nil {
+		return blobserver.SizedBlobRef{}, err
+	}
+	return dest.ReceiveBlob(br, bytes.NewReader(data))
+}
+
+// FetchStreaming tries every backend the write rule could have
+// routed br to, in order, and returns the first one that has it.
+func (s *storage) FetchStreaming(br blob.Ref) (io.ReadCloser, int64, error) {
+	var lastErr error
+	for _, b := range s.candidates {
+		rc, size, err := b.FetchStreaming(br)
+		if err == nil {
+			return rc, size, nil
+		}
+		lastErr = err
+	}
+	return nil, 0, lastErr
+}
+
+// Stat asks every backend the write rule could have routed a blob
+// to, and reports each of blobs found in any of them, deduplicated
+// by ref.
+func (s *storage) Stat(dest chan<- blobserver.SizedBlobRef, blobs []blob.Ref, waitSeconds int) error {
+	found := map[string]blobserver.SizedBlobRef{}
+	for _, b := range s.candidates {
+		sub := make(chan blobserver.SizedBlobRef)
+		errc := make(chan error, 1)
+		go func(b blobserver.Storage) {
+			errc <- b.Stat(sub, blobs, waitSeconds)
+		}(b)
+		for sb := range sub {
+			if _, ok := found[sb.Ref.String()]; !ok {
+				found[sb.Ref.String()] = sb
+			}
+		}
+		if err := <-errc; err != nil {
+			return err
+		}
+	}
+	defer close(dest)
+	for _, sb := range found {
+		dest <- sb
+	}
+	return nil
+}
+
+// EnumerateBlobs merges the listings of every backend the write
+// rule could have routed a blob to, since a caller walking this
+// storage's contents must see blobs regardless of which backend
+// they actually landed in.
+func (s *storage) EnumerateBlobs(dest chan<- blobserver.SizedBlobRef, after string, limit int, waitSeconds int) error {
+	defer close(dest)
+	seen := map[string]bool{}
+	var all []blobserver.SizedBlobRef
+	for _, b := range s.candidates {
+		sub := make(chan blobserver.SizedBlobRef)
+		errc := make(chan error, 1)
+		go func(b blobserver.Storage) {
+			errc <- b.EnumerateBlobs(sub, "", 0, waitSeconds)
+		}(b)
+		for sb := range sub {
+			if !seen[sb.Ref.String()] {
+				seen[sb.Ref.String()] = true
+				all = append(all, sb)
+			}
+		}
+		if err := <-errc; err != nil {
+			return err
+		}
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Ref.String() < all[j].Ref.String() })
+	sent := 0
+	for _, sb := range all {
+		if sb.Ref.String() <= after {
+			continue
+		}
+		if limit > 0 && sent >= limit {
+			break
+		}
+		dest <- sb
+		sent++
+	}
+	return nil
+}
+
+// RemoveBlobs removes blobs from every backend the write rule could
+// have routed them to; RemoveBlobs is defined as a no-op on a
+// backend that doesn't have a given blob, so calling it on backends
+// that never received it is harmless.
+func (s *storage) RemoveBlobs(blobs []blob.Ref) error {
+	for _, b := range s.candidates {
+		if err := b.RemoveBlobs(blobs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
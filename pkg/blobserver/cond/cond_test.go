@@ -0,0 +1,264 @@
+/*
+Copyright 2013 The Camlistore Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cond
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"testing"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/blobserver"
+	"camlistore.org/pkg/jsonconfig"
+)
+
+// memStorage is a trivial in-memory blobserver.Storage, used so
+// tests can assert which backend a blob landed in.
+type memStorage struct {
+	blobs map[string][]byte
+}
+
+func newMemStorage() *memStorage { return &memStorage{blobs: map[string][]byte{}} }
+
+func (m *memStorage) ReceiveBlob(br blob.Ref, src io.Reader) (blobserver.SizedBlobRef, error) {
+	data, err := ioutil.ReadAll(src)
+	if err != nil {
+		return blobserver.SizedBlobRef{}, err
+	}
+	m.blobs[br.String()] = data
+	return blobserver.SizedBlobRef{Ref: br, Size: int64(len(data))}, nil
+}
+
+func (m *memStorage) FetchStreaming(br blob.Ref) (io.ReadCloser, int64, error) {
+	data, ok := m.blobs[br.String()]
+	if !ok {
+		return nil, 0, fmt.Errorf("blob %v not found", br)
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), int64(len(data)), nil
+}
+
+func (m *memStorage) Stat(dest chan<- blobserver.SizedBlobRef, blobs []blob.Ref, waitSeconds int) error {
+	defer close(dest)
+	for _, br := range blobs {
+		if data, ok := m.blobs[br.String()]; ok {
+			dest <- blobserver.SizedBlobRef{Ref: br, Size: int64(len(data))}
+		}
+	}
+	return nil
+}
+
+func (m *memStorage) EnumerateBlobs(dest chan<- blobserver.SizedBlobRef, after string, limit int, waitSeconds int) error {
+	defer close(dest)
+	var refs []string
+	for ref := range m.blobs {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+	sent := 0
+	for _, ref := range refs {
+		if ref <= after {
+			continue
+		}
+		if limit > 0 && sent >= limit {
+			break
+		}
+		dest <- blobserver.SizedBlobRef{Ref: blob.Parse(ref), Size: int64(len(m.blobs[ref]))}
+		sent++
+	}
+	return nil
+}
+
+func (m *memStorage) RemoveBlobs(blobs []blob.Ref) error {
+	for _, br := range blobs {
+		delete(m.blobs, br.String())
+	}
+	return nil
+}
+
+func init() {
+	blobserver.RegisterStorageConstructor("_condtest_mem", func(jsonconfig.Obj) (blobserver.Storage, error) {
+		return newMemStorage(), nil
+	})
+}
+
+func newCond(t *testing.T, write interface{}) *storage {
+	t.Helper()
+	config := jsonconfig.Obj{
+		"backends": map[string]interface{}{
+			"small": map[string]interface{}{"type": "_condtest_mem"},
+			"large": map[string]interface{}{"type": "_condtest_mem"},
+		},
+		"read":   "small",
+		"remove": "small",
+		"write":  write,
+	}
+	st, err := newFromConfig(config)
+	if err != nil {
+		t.Fatalf("newFromConfig: %v", err)
+	}
+	return st.(*storage)
+}
+
+func TestCondRoutesBySize(t *testing.T) {
+	s := newCond(t, map[string]interface{}{
+		"if":   "size < 32k",
+		"then": "small",
+		"else": "large",
+	})
+	small := bytes.Repeat([]byte("a"), 10)
+	large := bytes.Repeat([]byte("b"), 64<<10)
+
+	if _, err := s.ReceiveBlob(blob.FromBytes("sha1", small), bytes.NewReader(small)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ReceiveBlob(blob.FromBytes("sha1", large), bytes.NewReader(large)); err != nil {
+		t.Fatal(err)
+	}
+
+	smallBackend := s.backends["small"].(*memStorage)
+	largeBackend := s.backends["large"].(*memStorage)
+	if len(smallBackend.blobs) != 1 {
+		t.Errorf("small backend has %d blobs, want 1", len(smallBackend.blobs))
+	}
+	if len(largeBackend.blobs) != 1 {
+		t.Errorf("large backend has %d blobs, want 1", len(largeBackend.blobs))
+	}
+}
+
+func TestCondRoutesByType(t *testing.T) {
+	s := newCond(t, map[string]interface{}{
+		"if":   "type == schema",
+		"then": "small",
+		"else": "large",
+	})
+	schemaBlob := []byte(`{"camliVersion":1,"camliType":"file"}`)
+	rawBlob := bytes.Repeat([]byte("x"), 100)
+
+	if _, err := s.ReceiveBlob(blob.FromBytes("sha1", schemaBlob), bytes.NewReader(schemaBlob)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ReceiveBlob(blob.FromBytes("sha1", rawBlob), bytes.NewReader(rawBlob)); err != nil {
+		t.Fatal(err)
+	}
+
+	smallBackend := s.backends["small"].(*memStorage)
+	largeBackend := s.backends["large"].(*memStorage)
+	if len(smallBackend.blobs) != 1 {
+		t.Errorf("schema blob landed in wrong backend: small has %d blobs, want 1", len(smallBackend.blobs))
+	}
+	if len(largeBackend.blobs) != 1 {
+		t.Errorf("raw blob landed in wrong backend: large has %d blobs, want 1", len(largeBackend.blobs))
+	}
+}
+
+func TestCondNewFromConfigRejectsMissingRead(t *testing.T) {
+	config := jsonconfig.Obj{
+		"backends": map[string]interface{}{
+			"small": map[string]interface{}{"type": "_condtest_mem"},
+		},
+		// "read" is omitted.
+		"remove": "small",
+		"write":  "small",
+	}
+	if _, err := newFromConfig(config); err == nil {
+		t.Fatal("newFromConfig with no \"read\" key: got nil error, want one")
+	}
+}
+
+// TestCondReadsBackBlobRoutedAwayFromReadBackend writes a blob that
+// the size rule routes to "large" while "read"/"remove" are
+// configured as "small", and verifies that FetchStreaming, Stat,
+// EnumerateBlobs and RemoveBlobs all still find it through s itself
+// -- not by reaching into a specific backend's internals the way
+// TestCondRoutesBySize does.
+func TestCondReadsBackBlobRoutedAwayFromReadBackend(t *testing.T) {
+	s := newCond(t, map[string]interface{}{
+		"if":   "size < 32k",
+		"then": "small",
+		"else": "large",
+	})
+	large := bytes.Repeat([]byte("b"), 64<<10)
+	ref := blob.FromBytes("sha1", large)
+	if _, err := s.ReceiveBlob(ref, bytes.NewReader(large)); err != nil {
+		t.Fatal(err)
+	}
+
+	rc, size, err := s.FetchStreaming(ref)
+	if err != nil {
+		t.Fatalf("FetchStreaming: %v", err)
+	}
+	defer rc.Close()
+	got, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("reading fetched blob: %v", err)
+	}
+	if !bytes.Equal(got, large) || size != int64(len(large)) {
+		t.Errorf("FetchStreaming returned %d bytes (size %d); want %d bytes", len(got), size, len(large))
+	}
+
+	statDest := make(chan blobserver.SizedBlobRef)
+	statErr := make(chan error, 1)
+	go func() { statErr <- s.Stat(statDest, []blob.Ref{ref}, 0) }()
+	var statted []blobserver.SizedBlobRef
+	for sb := range statDest {
+		statted = append(statted, sb)
+	}
+	if err := <-statErr; err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if len(statted) != 1 || statted[0].Ref != ref {
+		t.Errorf("Stat returned %v; want a single entry for %v", statted, ref)
+	}
+
+	enumDest := make(chan blobserver.SizedBlobRef)
+	enumErr := make(chan error, 1)
+	go func() { enumErr <- s.EnumerateBlobs(enumDest, "", 0, 0) }()
+	var enumerated []blobserver.SizedBlobRef
+	for sb := range enumDest {
+		enumerated = append(enumerated, sb)
+	}
+	if err := <-enumErr; err != nil {
+		t.Fatalf("EnumerateBlobs: %v", err)
+	}
+	if len(enumerated) != 1 || enumerated[0].Ref != ref {
+		t.Errorf("EnumerateBlobs returned %v; want a single entry for %v", enumerated, ref)
+	}
+
+	if err := s.RemoveBlobs([]blob.Ref{ref}); err != nil {
+		t.Fatalf("RemoveBlobs: %v", err)
+	}
+	if _, _, err := s.FetchStreaming(ref); err == nil {
+		t.Error("FetchStreaming after RemoveBlobs: got nil error, want not-found")
+	}
+}
+
+func TestCondSingleBackendWrite(t *testing.T) {
+	s := newCond(t, "small")
+	data := []byte("hello")
+	if _, err := s.ReceiveBlob(blob.FromBytes("sha1", data), bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.backends["small"].(*memStorage).blobs) != 1 {
+		t.Errorf("expected blob in \"small\" backend")
+	}
+	if len(s.backends["large"].(*memStorage).blobs) != 0 {
+		t.Errorf("expected no blob in \"large\" backend")
+	}
+}
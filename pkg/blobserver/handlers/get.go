@@ -0,0 +1,142 @@
+/*
+Copyright 2013 The Camlistore Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package handlers implements the HTTP handlers that expose a
+// blobserver.Storage to blob-protocol clients.
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/blobserver"
+	"camlistore.org/pkg/schema"
+)
+
+// CreateGetHandler returns an http.HandlerFunc that serves the raw
+// bytes of the blob named by the final path element of the request
+// URL, fetched from fetcher. It is invoked from
+// handleCamliUsingStorage for plain GETs of a blob.
+//
+// If the request carries a "via" query parameter -- a
+// comma-separated chain of blobrefs leading from an already-verified
+// share target to the requested blob -- the handler only serves the
+// blob if every hop in that chain is a "trusted" link, per
+// schema.TrustedTransitiveRefs, from the blob before it. A ref that
+// merely appears as a substring of some free-form field is not
+// trusted, so an "evil claim" blob cannot smuggle access to an
+// unrelated secret blob just by mentioning its ref. See issue 228.
+func CreateGetHandler(fetcher blobserver.BlobFetcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		target := blob.Parse(lastPathComponent(req.URL.Path))
+		if !target.Valid() {
+			http.Error(w, "malformed blobref", http.StatusBadRequest)
+			return
+		}
+		via, err := parseVia(req.FormValue("via"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(via) > 0 {
+			if err := checkViaChain(fetcher, via, target); err != nil {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+		}
+		serveBlob(w, fetcher, target)
+	}
+}
+
+func lastPathComponent(p string) string {
+	if i := strings.LastIndex(p, "/"); i >= 0 {
+		p = p[i+1:]
+	}
+	return p
+}
+
+// parseVia splits s, the raw "via" query parameter, into its
+// comma-separated blobrefs. A missing parameter (s == "") is
+// reported as zero refs and no error; a present but malformed
+// component is an error, not silently treated as absent, so a
+// caller can't bypass the via-chain check by passing garbage.
+func parseVia(s string) ([]blob.Ref, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var refs []blob.Ref
+	for _, part := range strings.Split(s, ",") {
+		ref := blob.Parse(part)
+		if !ref.Valid() {
+			return nil, fmt.Errorf("malformed via blobref %q", part)
+		}
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
+
+// checkViaChain verifies that target is reachable from via[0] by
+// following only trusted schema links through the rest of via, one
+// hop at a time.
+func checkViaChain(fetcher blobserver.BlobFetcher, via []blob.Ref, target blob.Ref) error {
+	chain := append(append([]blob.Ref(nil), via...), target)
+	for i := 0; i < len(chain)-1; i++ {
+		cur, next := chain[i], chain[i+1]
+		data, err := fetchAll(fetcher, cur)
+		if err != nil {
+			return fmt.Errorf("via chain: fetching %v: %v", cur, err)
+		}
+		if !refIn(schema.TrustedTransitiveRefs(data), next) {
+			return fmt.Errorf("via chain: %v has no trusted link to %v", cur, next)
+		}
+	}
+	return nil
+}
+
+func refIn(refs []blob.Ref, target blob.Ref) bool {
+	for _, r := range refs {
+		if r == target {
+			return true
+		}
+	}
+	return false
+}
+
+func fetchAll(fetcher blobserver.BlobFetcher, ref blob.Ref) ([]byte, error) {
+	rc, _, err := fetcher.FetchStreaming(ref)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
+
+func serveBlob(w http.ResponseWriter, fetcher blobserver.BlobFetcher, ref blob.Ref) {
+	rc, size, err := fetcher.FetchStreaming(ref)
+	if err != nil {
+		http.Error(w, "blob not found", http.StatusNotFound)
+		return
+	}
+	defer rc.Close()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	io.Copy(w, rc)
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2013 The Camlistore Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	"camlistore.org/pkg/blob"
+)
+
+// memFetcher is a trivial blobserver.BlobFetcher backed by a map.
+type memFetcher struct {
+	blobs map[string][]byte
+}
+
+func (m *memFetcher) put(data []byte) blob.Ref {
+	ref := blob.FromBytes("sha1", data)
+	m.blobs[ref.String()] = data
+	return ref
+}
+
+func (m *memFetcher) FetchStreaming(ref blob.Ref) (io.ReadCloser, int64, error) {
+	data, ok := m.blobs[ref.String()]
+	if !ok {
+		return nil, 0, fmt.Errorf("blob %v not found", ref)
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), int64(len(data)), nil
+}
+
+// TestViaChainDeniesUntrustedSubstringRef puts an "evil claim" blob
+// whose payload merely mentions a secret blobref in a free-form
+// string field, and verifies that following the via chain to that
+// secret ref is denied: the ref has to appear in a trusted field
+// (camliContent, members, entries, parts[].blobRef), not just
+// anywhere in the JSON.
+func TestViaChainDeniesUntrustedSubstringRef(t *testing.T) {
+	f := &memFetcher{blobs: map[string][]byte{}}
+
+	secret := f.put([]byte("the secret payload"))
+	legit := f.put([]byte("the blob the claim actually links to"))
+
+	evilClaim := f.put([]byte(fmt.Sprintf(
+		`{"camliVersion":1,"camliType":"claim","camliContent":%q,"note":"see also %s for details"}`,
+		legit.String(), secret.String())))
+
+	if err := checkViaChain(f, []blob.Ref{evilClaim}, secret); err == nil {
+		t.Fatal("expected via chain to secret ref (mentioned only in free-form text) to be denied")
+	}
+	if err := checkViaChain(f, []blob.Ref{evilClaim}, legit); err != nil {
+		t.Fatalf("expected via chain to camliContent ref to be allowed, got: %v", err)
+	}
+}
+
+func TestCreateGetHandlerDeniesUntrustedVia(t *testing.T) {
+	f := &memFetcher{blobs: map[string][]byte{}}
+	secret := f.put([]byte("shh"))
+	claim := f.put([]byte(fmt.Sprintf(`{"camliType":"claim","note":"%s"}`, secret.String())))
+
+	h := CreateGetHandler(f)
+	req := httptest.NewRequest("GET", "/camli/"+secret.String()+"?via="+claim.String(), nil)
+	rec := httptest.NewRecorder()
+	h(rec, req)
+
+	if rec.Code != 401 {
+		t.Errorf("GET with untrusted via chain = %d; want 401", rec.Code)
+	}
+}
+
+// TestCreateGetHandlerDeniesMalformedVia verifies that a malformed
+// "via" component fails closed rather than being treated the same
+// as no "via" parameter at all.
+func TestCreateGetHandlerDeniesMalformedVia(t *testing.T) {
+	f := &memFetcher{blobs: map[string][]byte{}}
+	secret := f.put([]byte("shh"))
+
+	h := CreateGetHandler(f)
+	req := httptest.NewRequest("GET", "/camli/"+secret.String()+"?via=not-a-real-blobref", nil)
+	rec := httptest.NewRecorder()
+	h(rec, req)
+
+	if rec.Code != 400 {
+		t.Errorf("GET with malformed via = %d; want 400", rec.Code)
+	}
+}
+
+func TestCreateGetHandlerAllowsTrustedVia(t *testing.T) {
+	f := &memFetcher{blobs: map[string][]byte{}}
+	content := f.put([]byte("hello world"))
+	claim := f.put([]byte(fmt.Sprintf(`{"camliType":"claim","camliContent":%q}`, content.String())))
+
+	h := CreateGetHandler(f)
+	req := httptest.NewRequest("GET", "/camli/"+content.String()+"?via="+claim.String(), nil)
+	rec := httptest.NewRecorder()
+	h(rec, req)
+
+	if rec.Code != 200 {
+		t.Fatalf("GET with trusted via chain = %d; want 200", rec.Code)
+	}
+	if rec.Body.String() != "hello world" {
+		t.Errorf("body = %q; want %q", rec.Body.String(), "hello world")
+	}
+}
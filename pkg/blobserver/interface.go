@@ -0,0 +1,78 @@
+/*
+Copyright 2013 The Camlistore Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package blobserver defines the interfaces implemented by blob
+// storage backends (local disk, S3, and friends).
+package blobserver
+
+import (
+	"io"
+
+	"camlistore.org/pkg/blob"
+)
+
+// SizedBlobRef pairs a blob.Ref with the size of the blob it names.
+type SizedBlobRef struct {
+	Ref  blob.Ref
+	Size int64
+}
+
+// BlobReceiver accepts and stores blobs pushed to it.
+type BlobReceiver interface {
+	// ReceiveBlob stores source, which must hash to the digest
+	// named by ref, and returns its size.
+	ReceiveBlob(ref blob.Ref, source io.Reader) (SizedBlobRef, error)
+}
+
+// BlobStatter reports which of a set of blobs are present.
+type BlobStatter interface {
+	// Stat sends a SizedBlobRef to dest for each of blobs that
+	// is present in the storage, then closes dest.
+	Stat(dest chan<- SizedBlobRef, blobs []blob.Ref, waitSeconds int) error
+}
+
+// BlobEnumerator lists the blobs present in a storage, in sorted
+// order, starting after the blob named by after.
+type BlobEnumerator interface {
+	EnumerateBlobs(dest chan<- SizedBlobRef, after string, limit int, waitSeconds int) error
+}
+
+// BlobFetcher retrieves blobs by ref.
+type BlobFetcher interface {
+	// FetchStreaming returns a reader for the contents of ref
+	// and its size. The caller must close the returned reader.
+	FetchStreaming(ref blob.Ref) (file io.ReadCloser, size int64, err error)
+}
+
+// StatReceiver is the subset of Storage needed to upload blobs:
+// checking what's already present, and pushing what's missing.
+type StatReceiver interface {
+	BlobStatter
+	BlobReceiver
+}
+
+// Storage is the interface implemented by a blob storage backend,
+// such as localdisk, s3, swift, or cond.
+type Storage interface {
+	BlobFetcher
+	BlobStatter
+	BlobReceiver
+	BlobEnumerator
+
+	// RemoveBlobs removes the given blobs, if present. It is
+	// not an error to remove a blob that doesn't exist.
+	RemoveBlobs(blobs []blob.Ref) error
+}
@@ -0,0 +1,59 @@
+/*
+Copyright 2013 The Camlistore Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blobserver
+
+import (
+	"fmt"
+	"sync"
+
+	"camlistore.org/pkg/jsonconfig"
+)
+
+// StorageConstructor builds a Storage implementation from its
+// configuration.
+type StorageConstructor func(config jsonconfig.Obj) (Storage, error)
+
+var (
+	mu           sync.Mutex
+	constructors = make(map[string]StorageConstructor)
+)
+
+// RegisterStorageConstructor registers a Storage implementation
+// under the given name (e.g. "localdisk", "s3", "swift"), so it can
+// be selected by name from a server's configuration. It is
+// typically called from the init function of the package
+// implementing the storage; callers then select it with a blank
+// import of that package.
+func RegisterStorageConstructor(name string, ctor StorageConstructor) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, dup := constructors[name]; dup {
+		panic("blobserver: RegisterStorageConstructor called twice for " + name)
+	}
+	constructors[name] = ctor
+}
+
+// NewStorage builds the Storage registered under name, using config.
+func NewStorage(name string, config jsonconfig.Obj) (Storage, error) {
+	mu.Lock()
+	ctor, ok := constructors[name]
+	mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("blobserver: unknown storage type %q", name)
+	}
+	return ctor(config)
+}
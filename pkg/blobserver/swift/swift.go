@@ -0,0 +1,337 @@
+/*
+Copyright 2013 The Camlistore Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package swift registers the "swift" blobserver storage type,
+// which stores blobs as objects in an OpenStack Swift container.
+package swift
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/blobserver"
+	"camlistore.org/pkg/jsonconfig"
+)
+
+func init() {
+	blobserver.RegisterStorageConstructor("swift", newFromConfig)
+}
+
+// storage implements blobserver.Storage, storing each blob as an
+// object named after its Ref in a single Swift container.
+type storage struct {
+	userName  string
+	tenant    string
+	secret    string // API key / password
+	authURL   string
+	region    string
+	container string
+
+	client *http.Client
+
+	mu         sync.Mutex
+	token      string // X-Auth-Token, once authenticated
+	storageURL string // the endpoint to use for object requests
+}
+
+func newFromConfig(config jsonconfig.Obj) (blobserver.Storage, error) {
+	s := &storage{
+		userName:  config.RequiredString("user_name"),
+		tenant:    config.RequiredString("tenant"),
+		secret:    config.RequiredString("secret"),
+		authURL:   config.RequiredString("auth_url"),
+		container: config.RequiredString("container"),
+		region:    config.OptionalString("region", ""),
+		client:    http.DefaultClient,
+	}
+	skipStartupCheck := config.OptionalBool("skipStartupCheck", false)
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	if !skipStartupCheck {
+		if err := s.checkContainerExists(); err != nil {
+			return nil, fmt.Errorf("swift: %v", err)
+		}
+	}
+	return s, nil
+}
+
+// checkContainerExists mirrors the S3 backend's bucket check: it
+// fails fast at startup if the configured container doesn't exist
+// or the credentials can't authenticate, rather than only on the
+// first upload.
+func (s *storage) checkContainerExists() error {
+	resp, err := s.doRequest("HEAD", "", nil, nil)
+	if err != nil {
+		return fmt.Errorf("could not reach container %q: %v", s.container, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("container %q not accessible: %v", s.container, resp.Status)
+	}
+	return nil
+}
+
+// authenticate obtains an auth token and the object-storage
+// endpoint URL from Swift's Keystone-style auth service.
+func (s *storage) authenticate() error {
+	body := map[string]interface{}{
+		"auth": map[string]interface{}{
+			"tenantName": s.tenant,
+			"passwordCredentials": map[string]string{
+				"username": s.userName,
+				"password": s.secret,
+			},
+		},
+	}
+	buf, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Post(s.authURL+"/tokens", "application/json", bytes.NewReader(buf))
+	if err != nil {
+		return fmt.Errorf("auth request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("auth failed: %v", resp.Status)
+	}
+	var ar authResponse
+	if err := json.NewDecoder(resp.Body).Decode(&ar); err != nil {
+		return fmt.Errorf("could not parse auth response: %v", err)
+	}
+	storageURL, err := ar.objectStoreURL(s.region)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.token = ar.Access.Token.ID
+	s.storageURL = storageURL
+	s.mu.Unlock()
+	return nil
+}
+
+type authResponse struct {
+	Access struct {
+		Token struct {
+			ID string `json:"id"`
+		} `json:"token"`
+		ServiceCatalog []struct {
+			Type      string `json:"type"`
+			Endpoints []struct {
+				Region    string `json:"region"`
+				PublicURL string `json:"publicURL"`
+			} `json:"endpoints"`
+		} `json:"serviceCatalog"`
+	} `json:"access"`
+}
+
+func (ar *authResponse) objectStoreURL(region string) (string, error) {
+	for _, svc := range ar.Access.ServiceCatalog {
+		if svc.Type != "object-store" {
+			continue
+		}
+		for _, ep := range svc.Endpoints {
+			if region == "" || ep.Region == region {
+				return ep.PublicURL, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no object-store endpoint found for region %q", region)
+}
+
+// newRequest builds an authenticated request for path (relative to
+// the container root) against the Swift object API, re-authenticating
+// first if we don't yet have a token.
+func (s *storage) newRequest(method, path string, body io.Reader) (*http.Request, error) {
+	s.mu.Lock()
+	needAuth := s.token == ""
+	s.mu.Unlock()
+	if needAuth {
+		if err := s.authenticate(); err != nil {
+			return nil, err
+		}
+	}
+	s.mu.Lock()
+	u := s.storageURL + "/" + s.container
+	if path != "" {
+		u += "/" + path
+	}
+	tok := s.token
+	s.mu.Unlock()
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Auth-Token", tok)
+	return req, nil
+}
+
+// doRequest issues an authenticated request against the Swift
+// object API and returns the response. If the token has expired or
+// been revoked server-side, the first attempt comes back 401
+// Unauthorized; doRequest clears the cached token and retries the
+// request once with a freshly authenticated one, rather than
+// failing every call from then on until camlistored is restarted.
+// body, if non-nil, is resent verbatim on that retry. query, if
+// non-nil, is encoded onto the request URL.
+func (s *storage) doRequest(method, path string, query url.Values, body []byte) (*http.Response, error) {
+	do := func() (*http.Response, error) {
+		var r io.Reader
+		if body != nil {
+			r = bytes.NewReader(body)
+		}
+		req, err := s.newRequest(method, path, r)
+		if err != nil {
+			return nil, err
+		}
+		if body != nil {
+			req.ContentLength = int64(len(body))
+		}
+		if query != nil {
+			req.URL.RawQuery = query.Encode()
+		}
+		return s.client.Do(req)
+	}
+	resp, err := do()
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		return resp, nil
+	}
+	resp.Body.Close()
+	s.mu.Lock()
+	s.token = ""
+	s.mu.Unlock()
+	return do()
+}
+
+func (s *storage) FetchStreaming(br blob.Ref) (io.ReadCloser, int64, error) {
+	resp, err := s.doRequest("GET", br.String(), nil, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, 0, fmt.Errorf("swift: blob %v not found", br)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, 0, fmt.Errorf("swift: fetching %v: %v", br, resp.Status)
+	}
+	return resp.Body, resp.ContentLength, nil
+}
+
+func (s *storage) ReceiveBlob(br blob.Ref, source io.Reader) (blobserver.SizedBlobRef, error) {
+	data, err := ioutil.ReadAll(source)
+	if err != nil {
+		return blobserver.SizedBlobRef{}, err
+	}
+	resp, err := s.doRequest("PUT", br.String(), nil, data)
+	if err != nil {
+		return blobserver.SizedBlobRef{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return blobserver.SizedBlobRef{}, fmt.Errorf("swift: storing %v: %v", br, resp.Status)
+	}
+	return blobserver.SizedBlobRef{Ref: br, Size: int64(len(data))}, nil
+}
+
+func (s *storage) RemoveBlobs(blobs []blob.Ref) error {
+	for _, br := range blobs {
+		resp, err := s.doRequest("DELETE", br.String(), nil, nil)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
+			return fmt.Errorf("swift: removing %v: %v", br, resp.Status)
+		}
+	}
+	return nil
+}
+
+func (s *storage) Stat(dest chan<- blobserver.SizedBlobRef, blobs []blob.Ref, waitSeconds int) error {
+	defer close(dest)
+	for _, br := range blobs {
+		resp, err := s.doRequest("HEAD", br.String(), nil, nil)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			continue
+		}
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+			return fmt.Errorf("swift: stat %v: %v", br, resp.Status)
+		}
+		size, _ := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+		dest <- blobserver.SizedBlobRef{Ref: br, Size: size}
+	}
+	return nil
+}
+
+// swiftObject is one entry of a container listing, as returned by
+// Swift when the listing is requested in JSON format.
+type swiftObject struct {
+	Name  string `json:"name"`
+	Bytes int64  `json:"bytes"`
+}
+
+func (s *storage) EnumerateBlobs(dest chan<- blobserver.SizedBlobRef, after string, limit int, waitSeconds int) error {
+	defer close(dest)
+	v := url.Values{}
+	v.Set("format", "json")
+	if after != "" {
+		v.Set("marker", after)
+	}
+	if limit > 0 {
+		v.Set("limit", strconv.Itoa(limit))
+	}
+	resp, err := s.doRequest("GET", "", v, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("swift: enumerate: %v", resp.Status)
+	}
+	var objs []swiftObject
+	if err := json.NewDecoder(resp.Body).Decode(&objs); err != nil {
+		return err
+	}
+	for _, o := range objs {
+		ref := blob.Parse(o.Name)
+		if !ref.Valid() {
+			continue
+		}
+		dest <- blobserver.SizedBlobRef{Ref: ref, Size: o.Bytes}
+	}
+	return nil
+}
@@ -0,0 +1,283 @@
+/*
+Copyright 2013 The Camlistore Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package swift
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/blobserver"
+)
+
+// fakeSwift is a minimal Keystone + Swift object-store stand-in: it
+// issues a new token on every POST /tokens, and only accepts
+// container/object requests bearing the most recently issued token,
+// so tests can simulate a server-side token revocation by simply
+// issuing a fresh one and leaving the storage unaware.
+type fakeSwift struct {
+	srv *httptest.Server
+
+	mu        sync.Mutex
+	token     string
+	authCount int
+	objects   map[string][]byte
+}
+
+// accountPath is the Keystone-issued object-store endpoint, which
+// names only the account, not the container; storage.newRequest
+// appends "/"+container itself, mirroring how the real service
+// catalog endpoint works.
+const (
+	accountPath   = "/v1/TestAccount"
+	containerName = "AUTH_test"
+)
+
+func newFakeSwift() *fakeSwift {
+	f := &fakeSwift{objects: map[string][]byte{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/tokens", f.handleAuth)
+	mux.HandleFunc(accountPath+"/", f.handleObject)
+	f.srv = httptest.NewServer(mux)
+	return f
+}
+
+func (f *fakeSwift) accountURL() string { return f.srv.URL + accountPath }
+
+func (f *fakeSwift) handleAuth(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.authCount++
+	f.token = fmt.Sprintf("tok%d", f.authCount)
+	tok := f.token
+	f.mu.Unlock()
+
+	var ar authResponse
+	ar.Access.Token.ID = tok
+	ar.Access.ServiceCatalog = []struct {
+		Type      string `json:"type"`
+		Endpoints []struct {
+			Region    string `json:"region"`
+			PublicURL string `json:"publicURL"`
+		} `json:"endpoints"`
+	}{
+		{
+			Type: "object-store",
+			Endpoints: []struct {
+				Region    string `json:"region"`
+				PublicURL string `json:"publicURL"`
+			}{
+				{Region: "the-region", PublicURL: f.accountURL()},
+			},
+		},
+	}
+	json.NewEncoder(w).Encode(ar)
+}
+
+// invalidateToken simulates the server revoking the current token
+// without the client knowing, e.g. because it expired.
+func (f *fakeSwift) invalidateToken() {
+	f.mu.Lock()
+	f.token = ""
+	f.mu.Unlock()
+}
+
+func (f *fakeSwift) handleObject(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	ok := f.token != "" && r.Header.Get("X-Auth-Token") == f.token
+	f.mu.Unlock()
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	rest := strings.TrimPrefix(r.URL.Path, accountPath+"/"+containerName)
+	name := strings.TrimPrefix(rest, "/")
+	if name == "" {
+		// Container-level request: the startup existence check, or
+		// an enumeration listing.
+		switch r.Method {
+		case "HEAD":
+			w.WriteHeader(http.StatusOK)
+		case "GET":
+			f.mu.Lock()
+			var objs []swiftObject
+			for n, data := range f.objects {
+				objs = append(objs, swiftObject{Name: n, Bytes: int64(len(data))})
+			}
+			f.mu.Unlock()
+			json.NewEncoder(w).Encode(objs)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.Method {
+	case "PUT":
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		f.objects[name] = data
+		w.WriteHeader(http.StatusCreated)
+	case "GET":
+		data, ok := f.objects[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	case "HEAD":
+		data, ok := f.objects[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(http.StatusOK)
+	case "DELETE":
+		if _, ok := f.objects[name]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(f.objects, name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func newTestStorage(f *fakeSwift) *storage {
+	return &storage{
+		userName:  "user",
+		tenant:    "tenant",
+		secret:    "secret",
+		authURL:   f.srv.URL,
+		container: "AUTH_test",
+		region:    "the-region",
+		client:    http.DefaultClient,
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	f := newFakeSwift()
+	defer f.srv.Close()
+	s := newTestStorage(f)
+
+	if err := s.authenticate(); err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	if s.token != "tok1" {
+		t.Errorf("token = %q, want %q", s.token, "tok1")
+	}
+	if s.storageURL != f.accountURL() {
+		t.Errorf("storageURL = %q, want %q", s.storageURL, f.accountURL())
+	}
+}
+
+func TestCheckContainerExists(t *testing.T) {
+	f := newFakeSwift()
+	defer f.srv.Close()
+	s := newTestStorage(f)
+
+	if err := s.checkContainerExists(); err != nil {
+		t.Errorf("checkContainerExists: %v", err)
+	}
+}
+
+func TestReceiveFetchStatRemoveRoundTrip(t *testing.T) {
+	f := newFakeSwift()
+	defer f.srv.Close()
+	s := newTestStorage(f)
+
+	data := []byte("hello swift")
+	ref := blob.FromBytes("sha1", data)
+
+	if _, err := s.ReceiveBlob(ref, bytes.NewReader(data)); err != nil {
+		t.Fatalf("ReceiveBlob: %v", err)
+	}
+
+	rc, size, err := s.FetchStreaming(ref)
+	if err != nil {
+		t.Fatalf("FetchStreaming: %v", err)
+	}
+	got, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatalf("reading fetched blob: %v", err)
+	}
+	if !bytes.Equal(got, data) || size != int64(len(data)) {
+		t.Errorf("FetchStreaming returned %q (size %d); want %q", got, size, data)
+	}
+
+	statDest := make(chan blobserver.SizedBlobRef)
+	statErr := make(chan error, 1)
+	go func() { statErr <- s.Stat(statDest, []blob.Ref{ref}, 0) }()
+	var statted []blobserver.SizedBlobRef
+	for sb := range statDest {
+		statted = append(statted, sb)
+	}
+	if err := <-statErr; err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if len(statted) != 1 || statted[0].Ref != ref || statted[0].Size != int64(len(data)) {
+		t.Errorf("Stat returned %v; want a single entry for %v with size %d", statted, ref, len(data))
+	}
+
+	if err := s.RemoveBlobs([]blob.Ref{ref}); err != nil {
+		t.Fatalf("RemoveBlobs: %v", err)
+	}
+	if _, _, err := s.FetchStreaming(ref); err == nil {
+		t.Error("FetchStreaming after RemoveBlobs: got nil error, want not-found")
+	}
+}
+
+func TestDoRequestRetriesOnceAfterTokenRevoked(t *testing.T) {
+	f := newFakeSwift()
+	defer f.srv.Close()
+	s := newTestStorage(f)
+
+	data := []byte("still here")
+	ref := blob.FromBytes("sha1", data)
+	if _, err := s.ReceiveBlob(ref, bytes.NewReader(data)); err != nil {
+		t.Fatalf("ReceiveBlob: %v", err)
+	}
+
+	// Simulate the server revoking the token out from under the
+	// client; the cached token is now stale.
+	f.invalidateToken()
+
+	rc, _, err := s.FetchStreaming(ref)
+	if err != nil {
+		t.Fatalf("FetchStreaming after token revocation: %v", err)
+	}
+	rc.Close()
+	if f.authCount != 2 {
+		t.Errorf("authCount = %d, want 2 (initial auth + one re-auth after 401)", f.authCount)
+	}
+}
@@ -0,0 +1,193 @@
+/*
+Copyright 2013 The Camlistore Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// IgnoreMatcher decides whether a file should be skipped by the
+// uploader, based on an ordered sequence of gitignore-style rules:
+// the ignoredFiles patterns from ~/.camli/config, followed by the
+// rules from any .camliignore files discovered while walking a
+// tree. Rules are evaluated in order and the last one that matches
+// a given path wins, so a later "!pattern" can re-include a path
+// that an earlier, broader pattern excluded. It is used by "camput
+// file" to decide which files under a root to skip.
+type IgnoreMatcher struct {
+	rules []ignoreRule
+}
+
+type ignoreRule struct {
+	base     string // directory the rule is rooted at; "" for the legacy global config patterns
+	negate   bool   // pattern was prefixed with "!"
+	dirOnly  bool   // pattern had a trailing "/" and only matches directories
+	anchored bool   // pattern had a leading "/", or came from the config list with a path separator
+	pattern  string // the pattern itself, with the above decoration stripped
+}
+
+// NewIgnoreMatcher returns an IgnoreMatcher seeded with the legacy
+// ignoredFiles patterns from ~/.camli/config, in the same
+// shell/path-component syntax understood by newIgnoreChecker.
+// Additional, more specific rules can be layered on top with
+// AddIgnoreFile or LoadCamliIgnore as a tree is walked.
+func NewIgnoreMatcher(ignoredFiles []string) *IgnoreMatcher {
+	m := &IgnoreMatcher{}
+	for _, p := range ignoredFiles {
+		p = expandUserDir(p)
+		m.rules = append(m.rules, ignoreRule{
+			anchored: strings.Contains(p, string(filepath.Separator)),
+			pattern:  p,
+		})
+	}
+	return m
+}
+
+// LoadCamliIgnore reads dir/.camliignore, if it exists, and appends
+// its rules to m, rooted at dir. It is meant to be called once per
+// directory while walking a tree, so that a .camliignore found
+// deeper in the tree is layered on top of (and can override) rules
+// found higher up.
+func (m *IgnoreMatcher) LoadCamliIgnore(dir string) error {
+	f, err := os.Open(filepath.Join(dir, ".camliignore"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return m.AddIgnoreFile(dir, f)
+}
+
+// AddIgnoreFile parses the gitignore-style rules read from r and
+// appends them to m, rooted at dir.
+func (m *IgnoreMatcher) AddIgnoreFile(dir string, r io.Reader) error {
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		if rule, ok := parseIgnoreLine(dir, sc.Text()); ok {
+			m.rules = append(m.rules, rule)
+		}
+	}
+	return sc.Err()
+}
+
+func parseIgnoreLine(dir, line string) (ignoreRule, bool) {
+	trimmed := strings.TrimRight(line, " \t")
+	if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+		return ignoreRule{}, false
+	}
+	rule := ignoreRule{base: dir}
+	if strings.HasPrefix(trimmed, "!") {
+		rule.negate = true
+		trimmed = trimmed[1:]
+	}
+	if strings.HasPrefix(trimmed, "/") {
+		rule.anchored = true
+		trimmed = trimmed[1:]
+	}
+	if strings.HasSuffix(trimmed, "/") {
+		rule.dirOnly = true
+		trimmed = strings.TrimSuffix(trimmed, "/")
+	}
+	rule.pattern = trimmed
+	return rule, true
+}
+
+// Match reports whether fullpath should be ignored. isDir indicates
+// whether fullpath names a directory, since a pattern ending in "/"
+// only ever matches directories.
+func (m *IgnoreMatcher) Match(fullpath string, isDir bool) bool {
+	ignored := false
+	for _, r := range m.rules {
+		if r.matches(fullpath, isDir) {
+			ignored = !r.negate
+		}
+	}
+	return ignored
+}
+
+func (r ignoreRule) matches(fullpath string, isDir bool) bool {
+	rel := fullpath
+	if r.base != "" {
+		if r.base != fullpath && !hasDirPrefix(r.base, fullpath) {
+			return false
+		}
+		rel = strings.TrimPrefix(strings.TrimPrefix(fullpath, r.base), string(filepath.Separator))
+	}
+	if r.anchored {
+		return r.matchRel(rel, isDir)
+	}
+	// Unanchored: the pattern may match starting at any path
+	// component, the same way a bare gitignore rule does.
+	segs := strings.Split(rel, string(filepath.Separator))
+	for i := range segs {
+		if r.matchRel(strings.Join(segs[i:], string(filepath.Separator)), isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchRel reports whether r's pattern matches rel. A pattern that
+// matches only a proper prefix of rel's path components has matched
+// an ancestor directory of rel, so rel is ignored right along with
+// it regardless of dirOnly or isDir; a pattern that consumes all of
+// rel is subject to dirOnly as usual.
+func (r ignoreRule) matchRel(rel string, isDir bool) bool {
+	pSegs := strings.Split(r.pattern, "/")
+	rSegs := strings.Split(rel, string(filepath.Separator))
+	full, ancestor := matchSegs(pSegs, rSegs)
+	if ancestor {
+		return true
+	}
+	return full && (!r.dirOnly || isDir)
+}
+
+// matchSegs reports whether pSegs, a slash-separated gitignore glob
+// ("**" stands for zero or more whole path components, and
+// filepath.Match wildcards apply within a single component),
+// matches rSegs exactly (full) or matches only a proper prefix of
+// rSegs (ancestor), meaning rSegs names something nested under the
+// directory the pattern matched.
+func matchSegs(pSegs, rSegs []string) (full, ancestor bool) {
+	if len(pSegs) == 0 {
+		return len(rSegs) == 0, len(rSegs) > 0
+	}
+	if pSegs[0] == "**" {
+		if len(pSegs) == 1 {
+			return true, false
+		}
+		for i := 0; i <= len(rSegs); i++ {
+			f, a := matchSegs(pSegs[1:], rSegs[i:])
+			full = full || f
+			ancestor = ancestor || a
+		}
+		return full, ancestor
+	}
+	if len(rSegs) == 0 {
+		return false, false
+	}
+	if ok, _ := filepath.Match(pSegs[0], rSegs[0]); !ok {
+		return false, false
+	}
+	return matchSegs(pSegs[1:], rSegs[1:])
+}
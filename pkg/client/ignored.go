@@ -0,0 +1,106 @@
+/*
+Copyright 2013 The Camlistore Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"path/filepath"
+	"strings"
+
+	"camlistore.org/pkg/osutil"
+)
+
+// newIgnoreChecker returns a function reporting whether a given
+// fullpath should be ignored, based on the patterns in ignoredFiles
+// (as found in the ignoredFiles key of ~/.camli/config). A pattern
+// may be an absolute or "~"-relative path, a bare file or directory
+// name matched against any path component, or a shell glob matched
+// with filepath.Match.
+func newIgnoreChecker(ignoredFiles []string) func(fullpath string) bool {
+	patterns := make([]string, len(ignoredFiles))
+	for i, p := range ignoredFiles {
+		patterns[i] = expandUserDir(p)
+	}
+	return func(fullpath string) bool {
+		for _, pattern := range patterns {
+			if pattern == fullpath {
+				return true
+			}
+			if hasDirPrefix(pattern, fullpath) {
+				return true
+			}
+			if strings.Contains(pattern, string(filepath.Separator)) {
+				if isShellPatternMatch(pattern, fullpath) {
+					return true
+				}
+				continue
+			}
+			if hasComponent(pattern, fullpath) {
+				return true
+			}
+			if isShellPatternMatch(pattern, fullpath) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// expandUserDir replaces a leading "~" in p with the user's home
+// directory.
+func expandUserDir(p string) string {
+	if p == "~" {
+		return osutil.HomeDir()
+	}
+	if strings.HasPrefix(p, "~/") {
+		return filepath.Join(osutil.HomeDir(), p[2:])
+	}
+	return p
+}
+
+// isShellPatternMatch reports whether fullpath matches pattern. If
+// pattern contains no path separator, it is matched against just
+// the base name of fullpath; otherwise pattern must match fullpath
+// in its entirety.
+func isShellPatternMatch(pattern, fullpath string) bool {
+	if !strings.Contains(pattern, string(filepath.Separator)) {
+		matched, _ := filepath.Match(pattern, filepath.Base(fullpath))
+		return matched
+	}
+	matched, _ := filepath.Match(pattern, fullpath)
+	return matched
+}
+
+// hasDirPrefix reports whether pattern names fullpath itself, or a
+// directory that is a path-component-wise prefix of fullpath.
+func hasDirPrefix(pattern, fullpath string) bool {
+	pattern = strings.TrimSuffix(pattern, string(filepath.Separator))
+	if pattern == fullpath {
+		return true
+	}
+	return strings.HasPrefix(fullpath, pattern+string(filepath.Separator))
+}
+
+// hasComponent reports whether pattern is equal to one of the path
+// components of fullpath.
+func hasComponent(pattern, fullpath string) bool {
+	for _, c := range strings.Split(fullpath, string(filepath.Separator)) {
+		if c == pattern {
+			return true
+		}
+	}
+	return false
+}
@@ -18,6 +18,7 @@ package client
 
 import (
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"camlistore.org/pkg/osutil"
@@ -128,3 +129,81 @@ func TestIgnoreFns(t *testing.T) {
 		}
 	}
 }
+
+type matcherTest struct {
+	rules []string // gitignore-style lines, rooted at "/root"
+	path  string
+	isDir bool
+	want  bool
+}
+
+func TestIgnoreMatcher(t *testing.T) {
+	tests := []matcherTest{
+		{
+			rules: []string{"*.jpg"},
+			path:  "/root/a/pony.jpg",
+			want:  true,
+		},
+		{
+			rules: []string{"*.jpg", "!important.jpg"},
+			path:  "/root/a/important.jpg",
+			want:  false,
+		},
+		{
+			rules: []string{"build/"},
+			path:  "/root/build",
+			isDir: true,
+			want:  true,
+		},
+		{
+			rules: []string{"build/"},
+			path:  "/root/build",
+			isDir: false,
+			want:  false,
+		},
+		{
+			rules: []string{"/cache"},
+			path:  "/root/a/cache",
+			want:  false,
+		},
+		{
+			rules: []string{"/cache"},
+			path:  "/root/cache",
+			want:  true,
+		},
+		{
+			rules: []string{"**/*.tmp"},
+			path:  "/root/a/b/c.tmp",
+			want:  true,
+		},
+		{
+			rules: []string{"*.jpg", "!*.jpg", "pony.jpg"},
+			path:  "/root/a/pony.jpg",
+			want:  true,
+		},
+		{
+			// A bare, single-segment pattern matches any path
+			// component, not only the last one.
+			rules: []string{"pony"},
+			path:  "/root/pony/rainbow.jpg",
+			want:  true,
+		},
+		{
+			// Everything under a matched directory is ignored
+			// along with it, even though the queried path itself
+			// isn't a directory.
+			rules: []string{"build/"},
+			path:  "/root/build/output.txt",
+			want:  true,
+		},
+	}
+	for i, v := range tests {
+		m := NewIgnoreMatcher(nil)
+		if err := m.AddIgnoreFile("/root", strings.NewReader(strings.Join(v.rules, "\n"))); err != nil {
+			t.Fatalf("test %d: AddIgnoreFile: %v", i, err)
+		}
+		if got := m.Match(v.path, v.isDir); got != v.want {
+			t.Errorf("test %d: Match(%q, %v) = %v; want %v", i, v.path, v.isDir, got, v.want)
+		}
+	}
+}
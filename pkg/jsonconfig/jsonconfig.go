@@ -0,0 +1,115 @@
+/*
+Copyright 2013 The Camlistore Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package jsonconfig provides a small helper type for reading typed
+// values out of a parsed JSON configuration object, as used by
+// server and blobserver storage configuration.
+package jsonconfig
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Obj is a parsed JSON object. Its Required*/Optional* accessors
+// consume (delete) the key they read, so that a call to Validate
+// after all of them reveals any keys the caller didn't know about.
+// A Required* call against a missing key doesn't fail immediately;
+// it records the problem in o, for Validate to report alongside any
+// unknown keys, so a constructor can make all of its Required* calls
+// up front and check for errors once at the end.
+type Obj map[string]interface{}
+
+// errKey is the map key Obj uses to stash accumulated errors from
+// Required* calls. It can't collide with a real JSON object key.
+const errKey = "\x00errs"
+
+func (o Obj) addError(err error) {
+	errs, _ := o[errKey].([]error)
+	o[errKey] = append(errs, err)
+}
+
+// RequiredString returns the string value of key. If key is absent
+// or not a string, it records an error for Validate to return and
+// returns "".
+func (o Obj) RequiredString(key string) string {
+	v, ok := o[key]
+	delete(o, key)
+	if !ok {
+		o.addError(fmt.Errorf("jsonconfig: required key %q is missing", key))
+		return ""
+	}
+	s, _ := v.(string)
+	return s
+}
+
+// OptionalString returns the string value of key, or def if it's
+// absent or not a string.
+func (o Obj) OptionalString(key, def string) string {
+	v, ok := o[key]
+	delete(o, key)
+	if !ok {
+		return def
+	}
+	s, _ := v.(string)
+	return s
+}
+
+// OptionalBool returns the bool value of key, or def if it's absent
+// or not a bool.
+func (o Obj) OptionalBool(key string, def bool) bool {
+	v, ok := o[key]
+	delete(o, key)
+	if !ok {
+		return def
+	}
+	b, _ := v.(bool)
+	return b
+}
+
+// Get returns the raw value of key, for callers that need to
+// inspect it before deciding how to interpret it (e.g. a value that
+// may be either a string or an object).
+func (o Obj) Get(key string) (interface{}, bool) {
+	v, ok := o[key]
+	delete(o, key)
+	return v, ok
+}
+
+// Validate returns an error describing any Required* calls that
+// found their key missing, and any keys left unconsumed by the
+// Required*/Optional* accessors, which usually means the
+// configuration has a typo or an option this version doesn't know
+// about.
+func (o Obj) Validate() error {
+	errs, _ := o[errKey].([]error)
+	delete(o, errKey)
+	if len(o) > 0 {
+		var keys []string
+		for k := range o {
+			keys = append(keys, k)
+		}
+		errs = append(errs, fmt.Errorf("jsonconfig: unknown key(s) in config: %v", keys))
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return fmt.Errorf("%s", strings.Join(msgs, "; "))
+}
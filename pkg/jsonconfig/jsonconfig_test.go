@@ -0,0 +1,46 @@
+/*
+Copyright 2013 The Camlistore Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jsonconfig
+
+import "testing"
+
+func TestRequiredStringMissingKeyFailsValidate(t *testing.T) {
+	o := Obj{"other": "value"}
+	if got := o.RequiredString("missing"); got != "" {
+		t.Errorf("RequiredString(missing) = %q, want \"\"", got)
+	}
+	if err := o.Validate(); err == nil {
+		t.Error("Validate() = nil after a RequiredString call found its key missing; want an error")
+	}
+}
+
+func TestRequiredStringPresentKeyPassesValidate(t *testing.T) {
+	o := Obj{"key": "value"}
+	if got := o.RequiredString("key"); got != "value" {
+		t.Errorf("RequiredString(key) = %q, want %q", got, "value")
+	}
+	if err := o.Validate(); err != nil {
+		t.Errorf("Validate() = %v, want nil", err)
+	}
+}
+
+func TestValidateReportsUnknownKeys(t *testing.T) {
+	o := Obj{"typo_key": "value"}
+	if err := o.Validate(); err == nil {
+		t.Error("Validate() = nil with an unconsumed key; want an error")
+	}
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2013 The Camlistore Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package schema reads and writes the JSON metadata blobs
+// ("schema blobs") that describe higher-level Camlistore objects,
+// such as files, built out of one or more raw content blobs.
+package schema
+
+import (
+	"bytes"
+	"io"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/blobserver"
+)
+
+// defaultFixedChunkSize is the chunk size used by NewFileWriter,
+// the historical fixed-boundary splitter: every chunk but the last
+// is exactly this many bytes.
+const defaultFixedChunkSize = 1 << 20 // 1MB
+
+// BytesPart is one span of a "bytes" or "file" schema's parts list:
+// a reference to a raw content blob and its size.
+type BytesPart struct {
+	BlobRef string `json:"blobRef"`
+	Size    int64  `json:"size"`
+}
+
+// fileSchema is the JSON schema blob describing a file as a
+// sequence of content blobs.
+type fileSchema struct {
+	CamliVersion int         `json:"camliVersion"`
+	CamliType    string      `json:"camliType"`
+	FileName     string      `json:"fileName"`
+	Size         int64       `json:"size"`
+	Parts        []BytesPart `json:"parts"`
+}
+
+// NewFileWriter uploads the contents of r to bs, splitting it into
+// fixed-size chunks of defaultFixedChunkSize, and returns the Ref
+// of the resulting "file" schema blob.
+func NewFileWriter(bs blobserver.StatReceiver, filename string, r io.Reader) (blob.Ref, error) {
+	return writeFileWithParts(bs, filename, r, fixedSizeParts)
+}
+
+// partsFunc splits the contents of r into content blobs, uploads
+// each to bs, and returns the parts list describing them in order.
+type partsFunc func(bs blobserver.StatReceiver, r io.Reader) ([]BytesPart, int64, error)
+
+func writeFileWithParts(bs blobserver.StatReceiver, filename string, r io.Reader, split partsFunc) (blob.Ref, error) {
+	parts, size, err := split(bs, r)
+	if err != nil {
+		return blob.Ref{}, err
+	}
+	return uploadFileSchema(bs, filename, size, parts)
+}
+
+func fixedSizeParts(bs blobserver.StatReceiver, r io.Reader) ([]BytesPart, int64, error) {
+	var parts []BytesPart
+	var total int64
+	buf := make([]byte, defaultFixedChunkSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			part, uerr := uploadBytes(bs, buf[:n])
+			if uerr != nil {
+				return nil, 0, uerr
+			}
+			parts = append(parts, part)
+			total += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	return parts, total, nil
+}
+
+// uploadBytes uploads data as a raw content blob to bs and returns
+// the BytesPart describing it.
+func uploadBytes(bs blobserver.BlobReceiver, data []byte) (BytesPart, error) {
+	ref := blob.FromBytes("sha1", data)
+	if _, err := bs.ReceiveBlob(ref, bytes.NewReader(data)); err != nil {
+		return BytesPart{}, err
+	}
+	return BytesPart{BlobRef: ref.String(), Size: int64(len(data))}, nil
+}
+
+func uploadFileSchema(bs blobserver.BlobReceiver, filename string, size int64, parts []BytesPart) (blob.Ref, error) {
+	fs := fileSchema{
+		CamliVersion: 1,
+		CamliType:    "file",
+		FileName:     filename,
+		Size:         size,
+		Parts:        parts,
+	}
+	jsonBlob, err := marshalSchema(fs)
+	if err != nil {
+		return blob.Ref{}, err
+	}
+	ref := blob.FromBytes("sha1", jsonBlob)
+	if _, err := bs.ReceiveBlob(ref, bytes.NewReader(jsonBlob)); err != nil {
+		return blob.Ref{}, err
+	}
+	return ref, nil
+}
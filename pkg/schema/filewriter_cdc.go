@@ -0,0 +1,147 @@
+/*
+Copyright 2013 The Camlistore Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schema
+
+import (
+	"bufio"
+	"io"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/blobserver"
+)
+
+// Content-defined chunking (CDC) splits a byte stream into chunks
+// whose boundaries are determined by the stream's contents, via a
+// buzhash-style rolling hash over a 64-byte window, rather than by
+// fixed byte offsets. Editing a file in place (log rotation, a VM
+// image, an mbox) shifts the fixed-size splitter's boundaries for
+// every chunk after the edit, while CDC boundaries "resync" shortly
+// after the edit, so only a small, roughly constant number of new
+// chunks need to be uploaded.
+const (
+	cdcWindowSize = 64          // bytes considered by the rolling hash
+	cdcMinChunk   = 256 << 10   // never split smaller than this
+	cdcMaxChunk   = 8 << 20     // always split at least this often
+	cdcTargetBits = 20          // 2^20 = 1MB average chunk size
+	cdcTargetMask = 1<<cdcTargetBits - 1
+)
+
+// buzTable is a fixed, deterministic table of per-byte-value
+// constants used by the rolling hash below. It need not be secret
+// or cryptographically strong, only well distributed and stable
+// across runs, since two uploaders must agree on chunk boundaries
+// for dedup to work.
+var buzTable = newBuzTable()
+
+func newBuzTable() [256]uint64 {
+	var t [256]uint64
+	seed := uint64(0x9e3779b97f4a7c15)
+	for i := range t {
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		z ^= z >> 31
+		t[i] = z
+	}
+	return t
+}
+
+func rol64(x uint64, s uint) uint64 {
+	s &= 63
+	if s == 0 {
+		return x
+	}
+	return (x << s) | (x >> (64 - s))
+}
+
+// cdcRoller implements the rolling hash over the trailing
+// cdcWindowSize bytes of the stream seen so far.
+type cdcRoller struct {
+	window [cdcWindowSize]byte
+	pos    int  // next slot in window to fill
+	filled int  // number of valid bytes in window, capped at cdcWindowSize
+	h      uint64
+}
+
+// roll folds in the next byte and returns the updated hash.
+func (c *cdcRoller) roll(b byte) uint64 {
+	out := c.window[c.pos]
+	c.window[c.pos] = b
+	c.pos = (c.pos + 1) % cdcWindowSize
+	if c.filled < cdcWindowSize {
+		c.filled++
+		c.h = rol64(c.h, 1) ^ buzTable[b]
+		return c.h
+	}
+	c.h = rol64(c.h, 1) ^ rol64(buzTable[out], cdcWindowSize) ^ buzTable[b]
+	return c.h
+}
+
+// NewFileWriterCDC uploads the contents of r to bs using
+// content-defined chunking instead of NewFileWriter's fixed-size
+// splitter, and returns the Ref of the resulting "file" schema
+// blob. It emits the same "bytes"/"file" style schema blobs as
+// NewFileWriter, so any existing reader can consume either.
+func NewFileWriterCDC(bs blobserver.StatReceiver, filename string, r io.Reader) (blob.Ref, error) {
+	return writeFileWithParts(bs, filename, r, cdcParts)
+}
+
+func cdcParts(bs blobserver.StatReceiver, r io.Reader) ([]BytesPart, int64, error) {
+	br := bufio.NewReader(r)
+	var (
+		parts []BytesPart
+		total int64
+		chunk []byte
+		roll  cdcRoller
+	)
+	flush := func() error {
+		if len(chunk) == 0 {
+			return nil
+		}
+		part, err := uploadBytes(bs, chunk)
+		if err != nil {
+			return err
+		}
+		parts = append(parts, part)
+		total += int64(len(chunk))
+		chunk = nil
+		roll = cdcRoller{}
+		return nil
+	}
+	for {
+		b, err := br.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		chunk = append(chunk, b)
+		h := roll.roll(b)
+		atBoundary := len(chunk) >= cdcMinChunk && h&cdcTargetMask == 0
+		if atBoundary || len(chunk) >= cdcMaxChunk {
+			if err := flush(); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return nil, 0, err
+	}
+	return parts, total, nil
+}
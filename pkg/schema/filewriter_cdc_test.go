@@ -0,0 +1,83 @@
+/*
+Copyright 2013 The Camlistore Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schema
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/blobserver"
+)
+
+// memStore is a trivial in-memory blobserver.StatReceiver used to
+// count how many distinct blobs an upload produces.
+type memStore struct {
+	blobs map[string][]byte
+}
+
+func newMemStore() *memStore { return &memStore{blobs: map[string][]byte{}} }
+
+func (m *memStore) ReceiveBlob(ref blob.Ref, src io.Reader) (blobserver.SizedBlobRef, error) {
+	data, err := ioutil.ReadAll(src)
+	if err != nil {
+		return blobserver.SizedBlobRef{}, err
+	}
+	m.blobs[ref.String()] = data
+	return blobserver.SizedBlobRef{Ref: ref, Size: int64(len(data))}, nil
+}
+
+func (m *memStore) Stat(dest chan<- blobserver.SizedBlobRef, blobs []blob.Ref, waitSeconds int) error {
+	close(dest)
+	return nil
+}
+
+func TestFileWriterCDCStableUnderEdit(t *testing.T) {
+	const size = 4 << 20 // 4MB, several chunks at the ~1MB target size
+	orig := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(orig)
+
+	before := newMemStore()
+	if _, err := NewFileWriterCDC(before, "f", bytes.NewReader(orig)); err != nil {
+		t.Fatalf("NewFileWriterCDC: %v", err)
+	}
+
+	edited := append([]byte(nil), orig...)
+	edited[size/2] ^= 0xff // flip a single byte in the middle
+
+	after := newMemStore()
+	if _, err := NewFileWriterCDC(after, "f", bytes.NewReader(edited)); err != nil {
+		t.Fatalf("NewFileWriterCDC: %v", err)
+	}
+
+	var newBlobs int
+	for ref := range after.blobs {
+		if _, ok := before.blobs[ref]; !ok {
+			newBlobs++
+		}
+	}
+	// Only the chunk(s) touching the edit should differ; a few
+	// is fine, but it must not be proportional to the number of
+	// chunks in the file (fixed-size splitting would invalidate
+	// almost all of them).
+	if newBlobs > 3 {
+		t.Errorf("editing one byte produced %d new blobs out of %d; want O(1)", newBlobs, len(after.blobs))
+	}
+}
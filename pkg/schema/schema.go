@@ -14,20 +14,11 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-// Package misc contains stuff which should probably move elsewhere.
-//
-// This is a gross place to put code.
-package misc
+package schema
 
-import (
-	"crypto/sha256"
-	"fmt"
-)
+import "encoding/json"
 
-// SHA256Prefix computes the SHA-256 digest of data and returns
-// its first twenty lowercase hex digits.
-func SHA256Prefix(data []byte) string {
-	h := sha256.New()
-	h.Write(data)
-	return fmt.Sprintf("%x", h.Sum(nil))[:20]
+// marshalSchema serializes v as a Camlistore JSON schema blob.
+func marshalSchema(v interface{}) ([]byte, error) {
+	return json.MarshalIndent(v, "", "  ")
 }
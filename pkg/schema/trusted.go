@@ -0,0 +1,72 @@
+/*
+Copyright 2013 The Camlistore Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schema
+
+import (
+	"encoding/json"
+
+	"camlistore.org/pkg/blob"
+)
+
+// TrustedTransitiveRefs parses blobBytes as a Camlistore JSON schema
+// blob and returns the refs it is safe to transitively follow from
+// it: those named by its "camliContent" and "members" fields, its
+// "entries" field, and the "blobRef" of each of its "parts". A
+// ref-shaped string appearing anywhere else in the blob, such as
+// inside a free-form string field, is deliberately not included:
+// trusting that would let an attacker smuggle an arbitrary ref into
+// a handler that follows "via" chains by embedding it in a claim's
+// prose rather than in a real reference field.
+func TrustedTransitiveRefs(blobBytes []byte) []blob.Ref {
+	var v map[string]interface{}
+	if json.Unmarshal(blobBytes, &v) != nil {
+		return nil
+	}
+	var refs []blob.Ref
+	addString := func(s string) {
+		if ref := blob.Parse(s); ref.Valid() {
+			refs = append(refs, ref)
+		}
+	}
+	addAny := func(x interface{}) {
+		if s, ok := x.(string); ok {
+			addString(s)
+		}
+	}
+	addAny(v["camliContent"])
+	if members, ok := v["members"].([]interface{}); ok {
+		for _, m := range members {
+			addAny(m)
+		}
+	}
+	switch entries := v["entries"].(type) {
+	case string:
+		addString(entries)
+	case []interface{}:
+		for _, e := range entries {
+			addAny(e)
+		}
+	}
+	if parts, ok := v["parts"].([]interface{}); ok {
+		for _, p := range parts {
+			if pm, ok := p.(map[string]interface{}); ok {
+				addAny(pm["blobRef"])
+			}
+		}
+	}
+	return refs
+}
@@ -36,6 +36,7 @@ import (
 	// Storage options:
 	"camli/blobserver/localdisk"
 	_ "camli/blobserver/s3"
+	_ "camli/blobserver/swift"
 	"camli/mysqlindexer"  // indexer, but uses storage interface
 )
 